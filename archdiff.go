@@ -2,381 +2,724 @@ package main
 
 import (
 	"bytes"
-	"crypto/md5"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"github.com/klauspost/compress/zstd"
 	"github.com/nshah/go.flagconfig"
-	"github.com/remyoudompheng/go-alpm"
+	"github.com/pkg/xattr"
+	dmp "github.com/sergi/go-diff/diffmatchpatch"
+	git "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/diff"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Morganamilo/archdiff/internal/scan"
 )
 
-type File struct {
-	Name string
-	Hash string
+// fullIgnoreGlobs is the ignore list used for a -scope=full (and
+// -scope=custom) scan: everything that's either pseudo-filesystem, volatile
+// state, or otherwise not meaningfully "configuration".
+var fullIgnoreGlobs = []string{
+	"/boot/grub/*stage*",
+	"/boot/initramfs-linux-fallback.img",
+	"/boot/initramfs-linux.img",
+	"/dev/*",
+	"/etc/.pwd.lock",
+	"/etc/group",
+	"/etc/group-",
+	"/etc/gshadow",
+	"/etc/gshadow-",
+	"/etc/ld.so.cache",
+	"/etc/mtab",
+	"/etc/pacman.d/gnupg/*",
+	"/etc/passwd",
+	"/etc/passwd-",
+	"/etc/profile.d/locale.sh",
+	"/etc/rndc.key",
+	"/etc/shadow",
+	"/etc/shadow-",
+	"/etc/shells",
+	"/etc/ssh/ssh_host_*key*",
+	"/etc/ssl/certs/*",
+	"/home/*",
+	"/lib/modules/*/modules*",
+	"/proc/*",
+	"/root/.bash_history",
+	"/root/.ssh/authorized_keys2",
+	"/root/.ssh/known_hosts",
+	"/run/*",
+	"/sys/*",
+	"/tmp/*",
+	"/usr/lib/gdk-pixbuf-2.0/2.10.0/loaders.cache",
+	"/usr/lib/locale/locale-archive",
+	"/usr/share/applications/mimeinfo.cache",
+	"/usr/share/fonts/*/fonts.dir",
+	"/usr/share/fonts/*/fonts.scale",
+	"/usr/share/glib-2.0/schemas/gschemas.compiled",
+	"/usr/share/info/dir",
+	"/usr/share/mime/version",
+	"/var/cache/fontconfig/*",
+	"/var/cache/ldconfig/*",
+	"/var/cache/man/*",
+	"/var/cache/pacman/*",
+	"/var/db/sudo/*",
+	"/var/lib/dbus/machine-id",
+	"/var/lib/dhcpcd/dhcpcd-eth0.lease",
+	"/var/lib/hwclock/adjtime",
+	"/var/lib/logrotate.status",
+	"/var/lib/misc/random-seed",
+	"/var/lib/mlocate/mlocate.db",
+	"/var/lib/pacman/*",
+	"/var/lib/postgres/data/*",
+	"/var/lib/random-seed",
+	"/var/lib/redis/dump.rdb",
+	"/var/lib/sudo/*",
+	"/var/lib/syslog-ng/syslog-ng.persist",
+	"/var/lock",
+	"/var/log/*",
+	"/var/run",
+	"/var/spool/*",
 }
 
-type ArchDiff struct {
-	Verbose     bool
-	Root        string
-	DB          string
-	Repo        string
-	IgnoreGlobs []string
-
-	backupFile         []File
-	modifiedBackupFile []File
-	localDb            *alpm.Db
-	alpmHandle         *alpm.Handle
-	allPackageFile     []File
-	allFile            []File
-	unpackagedFile     []File
-	repoFile           []File
-	diffRepoFile       []File
-	missingInRepo      []File
+// etcIgnoreGlobs is the ignore list used for a -scope=etc scan: just the
+// /etc paths that are either host-identity or otherwise never meant to be
+// tracked alongside the rest of a config repo.
+var etcIgnoreGlobs = []string{
+	"/etc/group",
+	"/etc/gshadow",
+	"/etc/passwd",
+	"/etc/shadow",
+	"/etc/shells",
+	"/etc/.pwd.lock",
+	"/etc/group-",
+	"/etc/gshadow-",
+	"/etc/ld.so.cache",
+	"/etc/pacman.d/gnupg/*",
+	"/etc/passwd-",
+	"/etc/profile.d/locale.sh",
+	"/etc/rndc.key",
+	"/etc/shadow-",
+	"/etc/ssh/ssh_host_*key*",
+	"/etc/ssl/certs/*",
 }
 
-func filehash(path string) (string, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return "", err
+func listNamed(sc *scan.Scanner, name string) []scan.File {
+	switch name {
+	case "missing-in-repo":
+		return sc.MissingInRepo()
+	case "different-in-repo":
+		return sc.DiffRepoFile()
+	case "package-backups":
+		return sc.BackupFile()
+	case "all":
+		return sc.AllFile()
+	case "package":
+		return sc.AllPackageFile()
+	case "modified-backups":
+		return sc.ModifiedBackupFile()
+	case "unpackaged":
+		return sc.UnpackagedFile()
+	case "repo":
+		return sc.RepoFile()
 	}
-	defer file.Close()
-	h := md5.New()
-	io.Copy(h, file)
-	return fmt.Sprintf("%x", h.Sum(nil)), nil
+	log.Fatalf("unknown list name: %s", name)
+	panic("not reached")
 }
 
-func contains(name string, list []File) bool {
-	for _, file := range list {
-		if file.Name == name {
-			return true
+func commandLs(sc *scan.Scanner, args []string) {
+	for _, name := range args[1:] {
+		fmt.Println(name)
+		for _, file := range listNamed(sc, name) {
+			fmt.Println(" ", file.Name)
 		}
 	}
-	return false
 }
 
-func (ad *ArchDiff) IsIgnored(path string) bool {
-	for _, glob := range ad.IgnoreGlobs {
-		matched, err := filepath.Match(glob, path)
-		if err != nil {
-			log.Fatalf("Match error: %s", err)
-		}
-		if matched {
-			return true
-		}
+func commandStatus(sc *scan.Scanner, args []string) {
+	commandLs(sc, []string{"ls", "missing-in-repo", "different-in-repo"})
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
 	}
-	return false
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	_, err = io.Copy(out, in)
+	return err
 }
 
-func (ad *ArchDiff) Alpm() *alpm.Handle {
-	if ad.alpmHandle == nil {
-		var err error
-		ad.alpmHandle, err = alpm.Init(ad.Root, ad.DB)
-		if err != nil {
-			log.Fatalf("Failed to initialize pacman: %s", err)
+// commandAdd copies every file reported by MissingInRepo() and
+// DiffRepoFile() from Root into Repo and stages it, mirroring `git add`.
+// DiffRepoFile() also reports a file that's been deleted from Root but is
+// still tracked in the repo; there's nothing to copy for those, so they're
+// staged as a removal instead.
+func commandAdd(sc *scan.Scanner, args []string) {
+	wt, err := sc.Git().Worktree()
+	if err != nil {
+		log.Fatalf("Error opening worktree: %s", err)
+	}
+	staged := append(append([]scan.File{}, sc.MissingInRepo()...), sc.DiffRepoFile()...)
+	for _, file := range staged {
+		src := filepath.Join(sc.Root, file.Name)
+		dst := filepath.Join(sc.Repo, file.Name)
+		if _, err := os.Stat(src); os.IsNotExist(err) {
+			if _, err := wt.Remove(file.Name); err != nil {
+				log.Fatalf("Error staging removal of %s: %s", file.Name, err)
+			}
+			continue
+		}
+		if err := copyFile(src, dst); err != nil {
+			log.Fatalf("Error copying %s into repo: %s", file.Name, err)
+		}
+		if _, err := wt.Add(file.Name); err != nil {
+			log.Fatalf("Error staging %s: %s", file.Name, err)
 		}
 	}
-	return ad.alpmHandle
 }
 
-func (ad *ArchDiff) Release() {
-	if ad.alpmHandle != nil {
-		ad.alpmHandle.Release()
+// commandCommit stages the same files as commandAdd and commits them.
+func commandCommit(sc *scan.Scanner, args []string) {
+	commandAdd(sc, args)
+
+	message := "archdiff: sync tracked files"
+	if len(args) > 1 {
+		message = strings.Join(args[1:], " ")
+	}
+
+	wt, err := sc.Git().Worktree()
+	if err != nil {
+		log.Fatalf("Error opening worktree: %s", err)
 	}
+	hash, err := wt.Commit(message, &git.CommitOptions{
+		Author: &object.Signature{
+			Name: "archdiff",
+			When: time.Now(),
+		},
+	})
+	if err != nil {
+		log.Fatalf("Error committing: %s", err)
+	}
+	log.Printf("committed %s", hash)
 }
 
-func (ad *ArchDiff) LocalDb() *alpm.Db {
-	if ad.localDb == nil {
-		var err error
-		ad.localDb, err = ad.Alpm().LocalDb()
+// commandDiff prints a unified diff between the working tree and the repo
+// for every file DiffRepoFile() reports as changed.
+func commandDiff(sc *scan.Scanner, args []string) {
+	for _, file := range sc.DiffRepoFile() {
+		patch, err := unifiedFileDiff(file.Name, filepath.Join(sc.Repo, file.Name), filepath.Join(sc.Root, file.Name))
 		if err != nil {
-			log.Fatalf("Error loading local DB: %s", err)
+			log.Printf("Error diffing %s: %s", file.Name, err)
+			continue
 		}
+		fmt.Print(patch)
 	}
-	return ad.localDb
 }
 
-func (ad *ArchDiff) BackupFile() []File {
-	if ad.backupFile == nil {
-		ad.LocalDb().PkgCache().ForEach(func(pkg alpm.Package) error {
-			return pkg.Backup().ForEach(func(bf alpm.BackupFile) error {
-				ad.backupFile = append(ad.backupFile, File{Name: bf.Name, Hash: bf.Hash})
-				return nil
-			})
-		})
+// snapshotChunkSize is the fixed chunk size snapshotted files are split
+// into before being stored content-addressed, restic-style.
+const snapshotChunkSize = 4 << 20 // 4MiB
+
+type snapshotFileEntry struct {
+	Path          string
+	Mode          uint32
+	Uid           int
+	Gid           int
+	Mtime         int64
+	Xattrs        map[string][]byte `json:",omitempty"`
+	Chunks        []string          `json:",omitempty"`
+	SymlinkTarget string            `json:",omitempty"`
+}
+
+type snapshotManifest struct {
+	Host  string
+	Time  time.Time
+	Files []snapshotFileEntry
+}
+
+func snapshotRepo(sc *scan.Scanner, snapshotRepoFlag string) string {
+	if snapshotRepoFlag != "" {
+		return snapshotRepoFlag
 	}
-	return ad.backupFile
+	return filepath.Join(sc.Repo, ".archdiff-snapshots")
 }
 
-func (ad *ArchDiff) AllFile() []File {
-	if ad.allFile == nil {
-		filepath.Walk(
-			ad.Root,
-			func(path string, info os.FileInfo, err error) error {
-				if ad.IsIgnored(path) {
-					if info.IsDir() {
-						return filepath.SkipDir
-					}
-					return nil
-				}
-				if info.IsDir() {
-					return nil
-				}
-				if err != nil {
-					if os.IsPermission(err) {
-						log.Printf("Skipping file: %s", err)
-						return nil
-					}
-					log.Fatalf("Error finding unpackaged file: %s", err)
-				}
-				ad.allFile = append(ad.allFile, File{Name: path[1:]})
-				return nil
-			})
-	}
-	return ad.allFile
+func snapshotDataDir(repo string) string     { return filepath.Join(repo, "data") }
+func snapshotManifestDir(repo string) string { return filepath.Join(repo, "snapshots") }
+
+// storeChunk writes data under data/<sha256[:2]>/<sha256>, skipping the
+// write if that blob is already present (restic-style deduplication).
+func storeChunk(repo string, data []byte) (string, error) {
+	sum := fmt.Sprintf("%x", sha256.Sum256(data))
+	dir := filepath.Join(snapshotDataDir(repo), sum[:2])
+	path := filepath.Join(dir, sum)
+	if _, err := os.Stat(path); err == nil {
+		return sum, nil
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	tmp, err := ioutil.TempFile(dir, "chunk-*.tmp")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	enc, err := zstd.NewWriter(tmp)
+	if err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if _, err := enc.Write(data); err != nil {
+		enc.Close()
+		tmp.Close()
+		return "", err
+	}
+	if err := enc.Close(); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	return sum, os.Rename(tmp.Name(), path)
 }
 
-func (ad *ArchDiff) AllPackageFile() []File {
-	if ad.allPackageFile == nil {
-		ad.LocalDb().PkgCache().ForEach(func(pkg alpm.Package) error {
-			for _, file := range pkg.Files() {
-				ad.allPackageFile = append(ad.allPackageFile, File{Name: file.Name})
-			}
-			return nil
-		})
+func loadChunk(repo, sum string) ([]byte, error) {
+	file, err := os.Open(filepath.Join(snapshotDataDir(repo), sum[:2], sum))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	dec, err := zstd.NewReader(file)
+	if err != nil {
+		return nil, err
 	}
-	return ad.allPackageFile
+	defer dec.Close()
+	return ioutil.ReadAll(dec)
 }
 
-func (ad *ArchDiff) ModifiedBackupFile() []File {
-	if ad.modifiedBackupFile == nil {
-		for _, file := range ad.BackupFile() {
-			fullname := filepath.Join(ad.Root, file.Name)
-			if ad.IsIgnored(fullname) {
-				continue
-			}
-			actual, err := filehash(fullname)
-			if err != nil {
-				if os.IsPermission(err) {
-					log.Printf("Skipping file: %s\n", err)
-					continue
-				}
-				log.Fatalf("Error calculating actual hash: %s", err)
-			}
-			if actual != file.Hash {
-				ad.modifiedBackupFile = append(ad.modifiedBackupFile, file)
-			}
+// readXattrs reads every extended attribute set on path, without following
+// a symlink. Filesystems that don't support xattrs at all report that as an
+// error on the initial list call, which isn't fatal to a snapshot.
+func readXattrs(path string) (map[string][]byte, error) {
+	names, err := xattr.LList(path)
+	if err != nil {
+		if errors.Is(err, syscall.ENOTSUP) {
+			return nil, nil
 		}
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, nil
 	}
-	return ad.modifiedBackupFile
+
+	xattrs := make(map[string][]byte, len(names))
+	for _, name := range names {
+		value, err := xattr.LGet(path, name)
+		if err != nil {
+			return nil, err
+		}
+		xattrs[name] = value
+	}
+	return xattrs, nil
 }
 
-func (ad *ArchDiff) UnpackagedFile() []File {
-	if ad.unpackagedFile == nil {
-		for _, file := range ad.AllFile() {
-			if !contains(file.Name, ad.AllPackageFile()) {
-				ad.unpackagedFile = append(ad.unpackagedFile, file)
-			}
+// writeXattrs restores extended attributes captured by readXattrs, without
+// following a symlink.
+func writeXattrs(path string, xattrs map[string][]byte) error {
+	for name, value := range xattrs {
+		if err := xattr.LSet(path, name, value); err != nil {
+			return err
 		}
 	}
-	return ad.unpackagedFile
+	return nil
 }
 
-func (ad *ArchDiff) RepoFile() []File {
-	if ad.repoFile == nil {
-		cmd := exec.Command("git", "ls-files")
-		cmd.Dir = ad.Repo
-		out, err := cmd.Output()
+// snapshotFile records a file's metadata and, for regular files, splits its
+// contents into snapshotChunkSize blobs stored under repo.
+func snapshotFile(root, repo, relName string) (snapshotFileEntry, error) {
+	fullPath := filepath.Join(root, relName)
+	info, err := os.Lstat(fullPath)
+	if err != nil {
+		return snapshotFileEntry{}, err
+	}
+
+	entry := snapshotFileEntry{
+		Path:  relName,
+		Mode:  uint32(info.Mode()),
+		Mtime: info.ModTime().UnixNano(),
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		entry.Uid = int(stat.Uid)
+		entry.Gid = int(stat.Gid)
+	}
+
+	xattrs, err := readXattrs(fullPath)
+	if err != nil {
+		return snapshotFileEntry{}, err
+	}
+	entry.Xattrs = xattrs
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(fullPath)
 		if err != nil {
-			log.Fatalf("Error listing repo files: %s", err)
+			return snapshotFileEntry{}, err
 		}
-		buf := bytes.NewBuffer(out)
-		for {
-			line, err := buf.ReadString('\n')
-			if err != nil {
-				if err == io.EOF {
-					break
-				}
-				log.Fatalf("Error parsing repo listing: %s", err)
+		entry.SymlinkTarget = target
+		return entry, nil
+	}
+
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return snapshotFileEntry{}, err
+	}
+	defer file.Close()
+
+	buf := make([]byte, snapshotChunkSize)
+	for {
+		n, err := io.ReadFull(file, buf)
+		if n > 0 {
+			sum, storeErr := storeChunk(repo, buf[:n])
+			if storeErr != nil {
+				return snapshotFileEntry{}, storeErr
 			}
-			ad.repoFile = append(
-				ad.repoFile, File{Name: line[:len(line)-1]}) // drop trailing \n
+			entry.Chunks = append(entry.Chunks, sum)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return snapshotFileEntry{}, err
 		}
 	}
-	return ad.repoFile
+	return entry, nil
 }
 
-func (ad *ArchDiff) DiffRepoFile() []File {
-	if ad.diffRepoFile == nil {
-		for _, file := range ad.RepoFile() {
-			realpath := filepath.Join(ad.Root, file.Name)
-			repopath := filepath.Join(ad.Repo, file.Name)
-			realhash, err := filehash(realpath)
-			if err != nil && !os.IsNotExist(err) {
-				if os.IsPermission(err) {
-					log.Printf("Skipping file: %s", err)
-					continue
-				}
-				log.Fatalf("Error looking for modified repo files (real): %s", err)
-			}
-			repohash, err := filehash(repopath)
-			if err != nil && !os.IsNotExist(err) {
-				if os.IsPermission(err) {
-					log.Printf("Skipping file: %s", err)
-					continue
-				}
-				log.Fatalf("Error looking for modified repo files (repo): %s", err)
-			}
-			if realhash != repohash {
-				ad.diffRepoFile = append(ad.diffRepoFile, file)
+// commandSnapshot writes the union of MissingInRepo() and
+// ModifiedBackupFile() into a restic-style content-addressed repository
+// under repo, as an alternative to tracking files in git.
+func commandSnapshot(sc *scan.Scanner, snapshotRepoFlag string, args []string) {
+	repo := snapshotRepo(sc, snapshotRepoFlag)
+	if err := os.MkdirAll(snapshotManifestDir(repo), 0755); err != nil {
+		log.Fatalf("Error preparing snapshot repo: %s", err)
+	}
+
+	seen := map[string]bool{}
+	var targets []scan.File
+	for _, file := range append(append([]scan.File{}, sc.MissingInRepo()...), sc.ModifiedBackupFile()...) {
+		if seen[file.Name] {
+			continue
+		}
+		seen[file.Name] = true
+		targets = append(targets, file)
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	manifest := snapshotManifest{Host: host, Time: time.Now()}
+
+	for _, file := range targets {
+		entry, err := snapshotFile(sc.Root, repo, file.Name)
+		if err != nil {
+			if os.IsPermission(err) || os.IsNotExist(err) {
+				log.Printf("Skipping file: %s", err)
+				continue
 			}
+			log.Fatalf("Error snapshotting %s: %s", file.Name, err)
 		}
+		manifest.Files = append(manifest.Files, entry)
 	}
-	return ad.diffRepoFile
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		log.Fatalf("Error encoding manifest: %s", err)
+	}
+	name := fmt.Sprintf("%s-%s.json", manifest.Time.UTC().Format("20060102T150405Z"), host)
+	if err := ioutil.WriteFile(filepath.Join(snapshotManifestDir(repo), name), data, 0644); err != nil {
+		log.Fatalf("Error writing manifest: %s", err)
+	}
+	log.Printf("wrote snapshot %s", name)
 }
 
-func (ad *ArchDiff) MissingInRepo() []File {
-	if ad.missingInRepo == nil {
-		for _, file := range ad.ModifiedBackupFile() {
-			if !contains(file.Name, ad.RepoFile()) {
-				ad.missingInRepo = append(ad.missingInRepo, file)
+// commandRestore reassembles a snapshot's files into -target.
+func commandRestore(sc *scan.Scanner, snapshotRepoFlag string, args []string) {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	snapshotID := flags.String("snapshot", "", "snapshot id (manifest filename, with or without .json)")
+	target := flags.String("target", "", "directory to restore into")
+	flags.Parse(args[1:])
+
+	if *snapshotID == "" || *target == "" {
+		log.Fatalf("restore requires -snapshot and -target")
+	}
+
+	repo := snapshotRepo(sc, snapshotRepoFlag)
+	name := *snapshotID
+	if !strings.HasSuffix(name, ".json") {
+		name += ".json"
+	}
+	data, err := ioutil.ReadFile(filepath.Join(snapshotManifestDir(repo), name))
+	if err != nil {
+		log.Fatalf("Error reading snapshot %s: %s", *snapshotID, err)
+	}
+
+	var manifest snapshotManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		log.Fatalf("Error parsing snapshot %s: %s", *snapshotID, err)
+	}
+
+	for _, entry := range manifest.Files {
+		dst := filepath.Join(*target, entry.Path)
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			log.Fatalf("Error preparing %s: %s", dst, err)
+		}
+
+		if entry.SymlinkTarget != "" {
+			os.Remove(dst)
+			if err := os.Symlink(entry.SymlinkTarget, dst); err != nil {
+				log.Fatalf("Error restoring symlink %s: %s", dst, err)
+			}
+			os.Lchown(dst, entry.Uid, entry.Gid)
+			if err := writeXattrs(dst, entry.Xattrs); err != nil {
+				log.Printf("Error restoring xattrs on %s: %s", dst, err)
 			}
+			continue
+		}
+
+		out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.FileMode(entry.Mode))
+		if err != nil {
+			log.Fatalf("Error restoring %s: %s", dst, err)
 		}
-		for _, file := range ad.UnpackagedFile() {
-			if !contains(file.Name, ad.RepoFile()) {
-				ad.missingInRepo = append(ad.missingInRepo, file)
+		for _, sum := range entry.Chunks {
+			chunk, err := loadChunk(repo, sum)
+			if err != nil {
+				out.Close()
+				log.Fatalf("Error reading chunk %s: %s", sum, err)
+			}
+			if _, err := out.Write(chunk); err != nil {
+				out.Close()
+				log.Fatalf("Error writing %s: %s", dst, err)
 			}
 		}
+		out.Close()
+		// OpenFile's mode only takes effect when it creates dst, and is
+		// subject to umask even then, so chmod explicitly to restore the
+		// exact recorded permissions over an existing file too.
+		if err := os.Chmod(dst, os.FileMode(entry.Mode)); err != nil {
+			log.Fatalf("Error restoring permissions on %s: %s", dst, err)
+		}
+		os.Chown(dst, entry.Uid, entry.Gid)
+		os.Chtimes(dst, time.Unix(0, entry.Mtime), time.Unix(0, entry.Mtime))
+		if err := writeXattrs(dst, entry.Xattrs); err != nil {
+			log.Printf("Error restoring xattrs on %s: %s", dst, err)
+		}
 	}
-	return ad.missingInRepo
+	log.Printf("restored snapshot %s into %s", *snapshotID, *target)
 }
 
-func (ad *ArchDiff) ListNamed(name string) []File {
-	switch name {
-	case "missing-in-repo":
-		return ad.MissingInRepo()
-	case "different-in-repo":
-		return ad.DiffRepoFile()
-	case "package-backups":
-		return ad.BackupFile()
-	case "all":
-		return ad.AllFile()
-	case "package":
-		return ad.AllPackageFile()
-	case "modified-backups":
-		return ad.ModifiedBackupFile()
-	case "unpackaged":
-		return ad.UnpackagedFile()
-	case "repo":
-		return ad.RepoFile()
+func commandUnknown(args []string) {
+	log.Fatalf("unknown command: %s", strings.Join(args, " "))
+}
+
+func runCommand(sc *scan.Scanner, snapshotRepoFlag string, args []string) {
+	switch args[0] {
+	case "ls":
+		commandLs(sc, args)
+	case "status":
+		commandStatus(sc, args)
+	case "add":
+		commandAdd(sc, args)
+	case "commit":
+		commandCommit(sc, args)
+	case "diff":
+		commandDiff(sc, args)
+	case "snapshot":
+		commandSnapshot(sc, snapshotRepoFlag, args)
+	case "restore":
+		commandRestore(sc, snapshotRepoFlag, args)
+	default:
+		commandUnknown(args)
 	}
-	log.Fatalf("unknown list name: %s", name)
-	panic("not reached")
 }
 
-func (ad *ArchDiff) CommandLs(args []string) {
-	for _, name := range args[1:] {
-		fmt.Println(name)
-		for _, file := range ad.ListNamed(name) {
-			fmt.Println(" ", file.Name)
+type simpleFile struct{ path string }
+
+func (f simpleFile) Hash() plumbing.Hash     { return plumbing.ZeroHash }
+func (f simpleFile) Mode() filemode.FileMode { return filemode.Regular }
+func (f simpleFile) Path() string            { return f.path }
+
+type simpleChunk struct {
+	content string
+	op      diff.Operation
+}
+
+func (c simpleChunk) Content() string      { return c.content }
+func (c simpleChunk) Type() diff.Operation { return c.op }
+
+type simpleFilePatch struct {
+	from, to diff.File
+	binary   bool
+	chunks   []diff.Chunk
+}
+
+func (p simpleFilePatch) IsBinary() bool                { return p.binary }
+func (p simpleFilePatch) Files() (diff.File, diff.File) { return p.from, p.to }
+func (p simpleFilePatch) Chunks() []diff.Chunk          { return p.chunks }
+
+type simplePatch struct {
+	filePatches []diff.FilePatch
+}
+
+func (p simplePatch) FilePatches() []diff.FilePatch { return p.filePatches }
+func (p simplePatch) Message() string               { return "" }
+
+func readFileOrEmpty(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
 		}
+		return nil, err
 	}
+	return data, nil
 }
 
-func (ad *ArchDiff) CommandStatus(args []string) {
-	ad.CommandLs([]string{"ls", "missing-in-repo", "different-in-repo"})
+// looksBinary applies git's own heuristic for classifying a blob as binary:
+// a NUL byte anywhere in the content (only the first binarySniffLen bytes are
+// checked, since that's enough to catch real binaries cheaply).
+const binarySniffLen = 8000
+
+func looksBinary(data []byte) bool {
+	if len(data) > binarySniffLen {
+		data = data[:binarySniffLen]
+	}
+	return bytes.IndexByte(data, 0) >= 0
 }
 
-func (ad *ArchDiff) CommandUnknown(args []string) {
-	log.Fatalf("unknown command: %s", strings.Join(args, " "))
+// unifiedFileDiff renders a unified diff between the copy of name tracked
+// in the repo and the copy currently on disk, via go-git's diff encoder.
+func unifiedFileDiff(name, repoPath, realPath string) (string, error) {
+	fromData, err := readFileOrEmpty(repoPath)
+	if err != nil {
+		return "", err
+	}
+	toData, err := readFileOrEmpty(realPath)
+	if err != nil {
+		return "", err
+	}
+
+	binary := looksBinary(fromData) || looksBinary(toData)
+	var chunks []diff.Chunk
+	if !binary {
+		chunks = diffLines(string(fromData), string(toData))
+	}
+
+	patch := simplePatch{
+		filePatches: []diff.FilePatch{
+			simpleFilePatch{
+				from:   simpleFile{path: name},
+				to:     simpleFile{path: name},
+				binary: binary,
+				chunks: chunks,
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	encoder := diff.NewUnifiedEncoder(&buf, diff.DefaultContextLines)
+	if err := encoder.Encode(patch); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
 }
 
-func (ad *ArchDiff) Command(args []string) {
-	switch args[0] {
-	case "ls":
-		ad.CommandLs(args)
-	case "status":
-		ad.CommandStatus(args)
-	default:
-		ad.CommandUnknown(args)
+// diffLines turns two texts into a minimal run of Equal/Delete/Add chunks,
+// via go-diff's line-mode diff: each line is mapped to a single rune so
+// Myers' algorithm runs over "characters" that are really whole lines, then
+// the result is expanded back to text. This keeps diffing a large tracked
+// file within roughly linear space, unlike a naive O(n·m) LCS table.
+func diffLines(from, to string) []diff.Chunk {
+	differ := dmp.New()
+	fromChars, toChars, lines := differ.DiffLinesToChars(from, to)
+	diffs := differ.DiffCharsToLines(differ.DiffMain(fromChars, toChars, false), lines)
+
+	chunks := make([]diff.Chunk, 0, len(diffs))
+	for _, d := range diffs {
+		op := diff.Equal
+		switch d.Type {
+		case dmp.DiffDelete:
+			op = diff.Delete
+		case dmp.DiffInsert:
+			op = diff.Add
+		}
+		chunks = append(chunks, simpleChunk{content: d.Text, op: op})
 	}
+	return chunks
 }
 
 func main() {
-	ad := &ArchDiff{}
-	flag.BoolVar(&ad.Verbose, "verbose", false, "verbose")
-	flag.StringVar(&ad.Root, "root", "/", "set an alternate installation root")
+	sc := &scan.Scanner{}
+	var scope, path, snapshotRepoFlag string
+	flag.BoolVar(&sc.Verbose, "verbose", false, "verbose")
+	flag.StringVar(&sc.Root, "root", "/", "set an alternate installation root")
 	flag.StringVar(
-		&ad.DB, "dbpath", "/var/lib/pacman", "set an alternate database location")
-	flag.StringVar(&ad.Repo, "repo", "", "repo directory")
-	ad.IgnoreGlobs = []string{
-		"/boot/grub/*stage*",
-		"/boot/initramfs-linux-fallback.img",
-		"/boot/initramfs-linux.img",
-		"/dev/*",
-		"/etc/.pwd.lock",
-		"/etc/group",
-		"/etc/group-",
-		"/etc/gshadow",
-		"/etc/gshadow-",
-		"/etc/ld.so.cache",
-		"/etc/mtab",
-		"/etc/pacman.d/gnupg/*",
-		"/etc/passwd",
-		"/etc/passwd-",
-		"/etc/profile.d/locale.sh",
-		"/etc/rndc.key",
-		"/etc/shadow",
-		"/etc/shadow-",
-		"/etc/shells",
-		"/etc/ssh/ssh_host_*key*",
-		"/etc/ssl/certs/*",
-		"/home/*",
-		"/lib/modules/*/modules*",
-		"/proc/*",
-		"/root/.bash_history",
-		"/root/.ssh/authorized_keys2",
-		"/root/.ssh/known_hosts",
-		"/run/*",
-		"/sys/*",
-		"/tmp/*",
-		"/usr/lib/gdk-pixbuf-2.0/2.10.0/loaders.cache",
-		"/usr/lib/locale/locale-archive",
-		"/usr/share/applications/mimeinfo.cache",
-		"/usr/share/fonts/*/fonts.dir",
-		"/usr/share/fonts/*/fonts.scale",
-		"/usr/share/glib-2.0/schemas/gschemas.compiled",
-		"/usr/share/info/dir",
-		"/usr/share/mime/version",
-		"/var/cache/fontconfig/*",
-		"/var/cache/ldconfig/*",
-		"/var/cache/man/*",
-		"/var/cache/pacman/*",
-		"/var/db/sudo/*",
-		"/var/lib/dbus/machine-id",
-		"/var/lib/dhcpcd/dhcpcd-eth0.lease",
-		"/var/lib/hwclock/adjtime",
-		"/var/lib/logrotate.status",
-		"/var/lib/misc/random-seed",
-		"/var/lib/mlocate/mlocate.db",
-		"/var/lib/pacman/*",
-		"/var/lib/postgres/data/*",
-		"/var/lib/random-seed",
-		"/var/lib/redis/dump.rdb",
-		"/var/lib/sudo/*",
-		"/var/lib/syslog-ng/syslog-ng.persist",
-		"/var/lock",
-		"/var/log/*",
-		"/var/run",
-		"/var/spool/*", /**/
-	}
+		&sc.DB, "dbpath", "/var/lib/pacman", "set an alternate database location")
+	flag.StringVar(&sc.Repo, "repo", "", "repo directory")
+	flag.StringVar(&sc.HashAlgo, "hash", "md5", "hash algorithm to use (md5, sha256, blake3)")
+	flag.IntVar(&sc.Jobs, "jobs", runtime.NumCPU(), "number of parallel walk/hash workers")
+	flag.StringVar(&sc.Backend, "backend", "", "package manager backend (pacman, dpkg, rpm); auto-detected if unset")
+	flag.StringVar(&snapshotRepoFlag, "snapshot-repo", "", "snapshot storage directory (default: <repo>/.archdiff-snapshots)")
+	flag.StringVar(&scope, "scope", "full", "what to scan: full (entire root), etc (/etc only, replaces etcdiff), or custom (-path under root)")
+	flag.StringVar(&path, "path", "", "scan root relative to -root, required when -scope=custom")
 
 	flag.Parse()
 	flagconfig.Parse()
 
-	ad.Command(flag.Args())
-}
\ No newline at end of file
+	switch scope {
+	case "full":
+		sc.ScanRoot = ""
+		sc.IgnoreGlobs = fullIgnoreGlobs
+	case "etc":
+		sc.ScanRoot = "etc"
+		sc.IgnoreGlobs = etcIgnoreGlobs
+	case "custom":
+		if path == "" {
+			log.Fatalf("-scope=custom requires -path")
+		}
+		sc.ScanRoot = path
+		sc.IgnoreGlobs = fullIgnoreGlobs
+	default:
+		log.Fatalf("unknown scope: %s", scope)
+	}
+
+	runCommand(sc, snapshotRepoFlag, flag.Args())
+
+	if err := sc.SaveHashCache(); err != nil {
+		log.Printf("Error saving hash cache: %s", err)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStoreLoadChunkRoundTrip(t *testing.T) {
+	repo := t.TempDir()
+	data := []byte("hello, archdiff snapshot data")
+
+	sum, err := storeChunk(repo, data)
+	if err != nil {
+		t.Fatalf("storeChunk() error = %s", err)
+	}
+
+	got, err := loadChunk(repo, sum)
+	if err != nil {
+		t.Fatalf("loadChunk() error = %s", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("loadChunk() = %q, want %q", got, data)
+	}
+}
+
+func TestStoreChunkDedupes(t *testing.T) {
+	repo := t.TempDir()
+	data := []byte("duplicate content")
+
+	sum1, err := storeChunk(repo, data)
+	if err != nil {
+		t.Fatalf("storeChunk() error = %s", err)
+	}
+	sum2, err := storeChunk(repo, data)
+	if err != nil {
+		t.Fatalf("storeChunk() second write error = %s", err)
+	}
+	if sum1 != sum2 {
+		t.Errorf("storeChunk() sums for identical content differ: %s vs %s", sum1, sum2)
+	}
+}
+
+func TestSnapshotFileRegularFile(t *testing.T) {
+	root := t.TempDir()
+	repo := t.TempDir()
+	content := []byte("config contents\n")
+	if err := os.WriteFile(filepath.Join(root, "foo.conf"), content, 0640); err != nil {
+		t.Fatalf("WriteFile() error = %s", err)
+	}
+
+	entry, err := snapshotFile(root, repo, "foo.conf")
+	if err != nil {
+		t.Fatalf("snapshotFile() error = %s", err)
+	}
+	if entry.Path != "foo.conf" {
+		t.Errorf("entry.Path = %q, want %q", entry.Path, "foo.conf")
+	}
+	if len(entry.Chunks) != 1 {
+		t.Fatalf("entry.Chunks has %d entries, want 1", len(entry.Chunks))
+	}
+	if entry.SymlinkTarget != "" {
+		t.Errorf("entry.SymlinkTarget = %q, want empty for a regular file", entry.SymlinkTarget)
+	}
+
+	got, err := loadChunk(repo, entry.Chunks[0])
+	if err != nil {
+		t.Fatalf("loadChunk() error = %s", err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Errorf("reassembled content = %q, want %q", got, content)
+	}
+}
+
+func TestSnapshotFileSymlink(t *testing.T) {
+	root := t.TempDir()
+	repo := t.TempDir()
+	if err := os.Symlink("/etc/target", filepath.Join(root, "link")); err != nil {
+		t.Fatalf("Symlink() error = %s", err)
+	}
+
+	entry, err := snapshotFile(root, repo, "link")
+	if err != nil {
+		t.Fatalf("snapshotFile() error = %s", err)
+	}
+	if entry.SymlinkTarget != "/etc/target" {
+		t.Errorf("entry.SymlinkTarget = %q, want %q", entry.SymlinkTarget, "/etc/target")
+	}
+	if len(entry.Chunks) != 0 {
+		t.Errorf("entry.Chunks = %v, want none for a symlink", entry.Chunks)
+	}
+}
@@ -0,0 +1,809 @@
+// Package scan holds the filesystem/package-database logic shared by every
+// archdiff scope (a full-root scan, an /etc-only scan, or a custom scan
+// root): walking the filesystem, hashing files, reading package-manager
+// backup/owned-file lists, and diffing all of that against a git repo.
+//
+// It used to be duplicated near-verbatim between archdiff.go and
+// etcdiff.go; Scanner is parameterized by Root/ScanRoot so both scopes can
+// share one implementation.
+package scan
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/gob"
+	"fmt"
+	"github.com/remyoudompheng/go-alpm"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+	git "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/gitignore"
+	"hash"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"log"
+	"lukechampine.com/blake3"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// File is a tracked path and, where known, the hash it's expected to have.
+type File struct {
+	Name string
+	Hash string
+}
+
+// Scanner collects the files under Root (or, if ScanRoot is set, under
+// Root/ScanRoot) and compares them against Repo and the package manager's
+// idea of what's installed. It's the common engine behind both a full-root
+// scan and an /etc-only scan: callers pick the scope by setting ScanRoot
+// and IgnoreGlobs before use.
+type Scanner struct {
+	Verbose     bool
+	Root        string
+	ScanRoot    string
+	DB          string
+	Repo        string
+	IgnoreGlobs []string
+	HashAlgo    string
+	Jobs        int
+	Backend     string
+
+	backupFile         []File
+	modifiedBackupFile []File
+	packageBackend     PackageBackend
+	allPackageFile     []File
+	allFile            []File
+	unpackagedFile     []File
+	repoFile           []File
+	diffRepoFile       []File
+	missingInRepo      []File
+	gitRepo            *git.Repository
+	gitIgnore          gitignore.Matcher
+	hashCacheOnce      sync.Once
+	hashCache          *hashCache
+}
+
+// root returns the directory AllFile walks: Root, or Root/ScanRoot if a
+// scan root narrower than Root was requested.
+func (sc *Scanner) root() string {
+	if sc.ScanRoot == "" {
+		return sc.Root
+	}
+	return filepath.Join(sc.Root, sc.ScanRoot)
+}
+
+// hashCacheEntry is what gets persisted for a single file: enough to tell,
+// without re-reading it, whether its contents could have changed. Algo
+// records which algorithm produced Sum, so switching -hash between runs
+// can't return a stale digest computed by the previous algorithm.
+type hashCacheEntry struct {
+	MtimeNS int64
+	Size    int64
+	Algo    string
+	Sum     string
+}
+
+// hashCache is a gob file under $XDG_CACHE_HOME/archdiff/hashes.db, keyed by
+// absolute path, that lets filehash() skip re-reading files whose mtime and
+// size haven't moved since the last run. Lookup/Store are called
+// concurrently by the ModifiedBackupFile worker pool, so entries are
+// guarded by mu.
+type hashCache struct {
+	path    string
+	mu      sync.Mutex
+	entries map[string]hashCacheEntry
+	dirty   bool
+}
+
+func hashCachePath() string {
+	dir := os.Getenv("XDG_CACHE_HOME")
+	if dir == "" {
+		dir = filepath.Join(os.Getenv("HOME"), ".cache")
+	}
+	return filepath.Join(dir, "archdiff", "hashes.db")
+}
+
+func loadHashCache() *hashCache {
+	return loadHashCacheFrom(hashCachePath())
+}
+
+func loadHashCacheFrom(path string) *hashCache {
+	hc := &hashCache{path: path, entries: map[string]hashCacheEntry{}}
+	file, err := os.Open(hc.path)
+	if err != nil {
+		return hc
+	}
+	defer file.Close()
+	if err := gob.NewDecoder(file).Decode(&hc.entries); err != nil {
+		log.Printf("Ignoring corrupt hash cache %s: %s", hc.path, err)
+		hc.entries = map[string]hashCacheEntry{}
+	}
+	return hc
+}
+
+func (hc *hashCache) Lookup(path, algo string, info os.FileInfo) (string, bool) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	entry, ok := hc.entries[path]
+	if !ok || entry.Algo != algo || entry.MtimeNS != info.ModTime().UnixNano() || entry.Size != info.Size() {
+		return "", false
+	}
+	return entry.Sum, true
+}
+
+func (hc *hashCache) Store(path, algo string, info os.FileInfo, sum string) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	hc.entries[path] = hashCacheEntry{
+		MtimeNS: info.ModTime().UnixNano(),
+		Size:    info.Size(),
+		Algo:    algo,
+		Sum:     sum,
+	}
+	hc.dirty = true
+}
+
+// Save atomically writes the cache back if anything changed.
+func (hc *hashCache) Save() error {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	if !hc.dirty {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(hc.path), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(hc.path), "hashes-*.db")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := gob.NewEncoder(tmp).Encode(hc.entries); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), hc.path)
+}
+
+// hashBufferPool reuses read buffers across filehash() calls to cut
+// allocations during full-tree scans.
+var hashBufferPool = sync.Pool{
+	New: func() interface{} { return make([]byte, 32*1024) },
+}
+
+func newHasher(algo string) (hash.Hash, error) {
+	switch algo {
+	case "md5":
+		return md5.New(), nil
+	case "sha256":
+		return sha256.New(), nil
+	case "blake3":
+		return blake3.New(32, nil), nil
+	default:
+		return nil, fmt.Errorf("unknown hash algorithm: %s", algo)
+	}
+}
+
+// HashCache lazily loads the on-disk hash cache. It's called concurrently
+// by the ModifiedBackupFile worker pool, so the lazy load itself is
+// guarded by a sync.Once.
+func (sc *Scanner) HashCache() *hashCache {
+	sc.hashCacheOnce.Do(func() {
+		sc.hashCache = loadHashCache()
+	})
+	return sc.hashCache
+}
+
+// SaveHashCache flushes the hash cache back to disk if anything changed.
+func (sc *Scanner) SaveHashCache() error {
+	return sc.HashCache().Save()
+}
+
+// filehash hashes path with sc.HashAlgo. It's only safe for comparisons
+// where both sides go through filehash themselves (e.g. DiffRepoFile, which
+// hashes the working tree and repo copy of the same file); comparing its
+// result against a digest that came from elsewhere (e.g. a package backend)
+// requires filehashAlgo with that backend's fixed algorithm instead.
+func (sc *Scanner) filehash(path string) (string, error) {
+	return sc.filehashAlgo(path, sc.HashAlgo)
+}
+
+func (sc *Scanner) filehashAlgo(path, algo string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if sum, ok := sc.HashCache().Lookup(path, algo, info); ok {
+		return sum, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	h, err := newHasher(algo)
+	if err != nil {
+		return "", err
+	}
+	buf := hashBufferPool.Get().([]byte)
+	defer hashBufferPool.Put(buf)
+	if _, err := io.CopyBuffer(h, file, buf); err != nil {
+		return "", err
+	}
+
+	sum := fmt.Sprintf("%x", h.Sum(nil))
+	sc.HashCache().Store(path, algo, info, sum)
+	return sum, nil
+}
+
+// pacmanBackend is the original alpm-backed behavior.
+type pacmanBackend struct {
+	root string
+	db   string
+
+	alpmHandle    *alpm.Handle
+	localDbHandle *alpm.Db
+}
+
+func (p *pacmanBackend) alpm() *alpm.Handle {
+	if p.alpmHandle == nil {
+		var err error
+		p.alpmHandle, err = alpm.Init(p.root, p.db)
+		if err != nil {
+			log.Fatalf("Failed to initialize pacman: %s", err)
+		}
+	}
+	return p.alpmHandle
+}
+
+func (p *pacmanBackend) localDb() *alpm.Db {
+	if p.localDbHandle == nil {
+		var err error
+		p.localDbHandle, err = p.alpm().LocalDb()
+		if err != nil {
+			log.Fatalf("Error loading local DB: %s", err)
+		}
+	}
+	return p.localDbHandle
+}
+
+func (p *pacmanBackend) Release() {
+	if p.alpmHandle != nil {
+		p.alpmHandle.Release()
+	}
+}
+
+func (p *pacmanBackend) BackupFiles() ([]File, error) {
+	var files []File
+	err := p.localDb().PkgCache().ForEach(func(pkg alpm.Package) error {
+		return pkg.Backup().ForEach(func(bf alpm.BackupFile) error {
+			files = append(files, File{Name: bf.Name, Hash: bf.Hash})
+			return nil
+		})
+	})
+	return files, err
+}
+
+func (p *pacmanBackend) OwnedFiles() ([]File, error) {
+	var files []File
+	err := p.localDb().PkgCache().ForEach(func(pkg alpm.Package) error {
+		for _, file := range pkg.Files() {
+			files = append(files, File{Name: file.Name})
+		}
+		return nil
+	})
+	return files, err
+}
+
+// dpkgBackend reads /var/lib/dpkg/status for tracked conffiles (Debian's
+// equivalent of a pacman backup file) and /var/lib/dpkg/info/*.md5sums for
+// every file a package owns.
+type dpkgBackend struct {
+	root string
+}
+
+// parseDpkgConffiles extracts the Conffiles: block of a dpkg status file
+// (one "<path> <md5>" pair per indented line) into Files with paths
+// relative to root, matching every other File producer in this package.
+func parseDpkgConffiles(status []byte) []File {
+	var files []File
+	inConffiles := false
+	for _, line := range strings.Split(string(status), "\n") {
+		switch {
+		case line == "Conffiles:":
+			inConffiles = true
+		case inConffiles && strings.HasPrefix(line, " "):
+			fields := strings.Fields(line)
+			if len(fields) == 2 {
+				files = append(files, File{Name: strings.TrimPrefix(fields[0], "/"), Hash: fields[1]})
+			}
+		default:
+			inConffiles = false
+		}
+	}
+	return files
+}
+
+// parseDpkgMd5sums parses one *.md5sums file (one "<md5>  <path>" pair per
+// line) into Files with paths relative to root.
+func parseDpkgMd5sums(data []byte) []File {
+	var files []File
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.SplitN(strings.TrimRight(line, "\n"), "  ", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		files = append(files, File{Name: strings.TrimPrefix(fields[1], "/"), Hash: fields[0]})
+	}
+	return files
+}
+
+func (d *dpkgBackend) BackupFiles() ([]File, error) {
+	data, err := ioutil.ReadFile(filepath.Join(d.root, "var/lib/dpkg/status"))
+	if err != nil {
+		return nil, err
+	}
+	return parseDpkgConffiles(data), nil
+}
+
+func (d *dpkgBackend) OwnedFiles() ([]File, error) {
+	matches, err := filepath.Glob(filepath.Join(d.root, "var/lib/dpkg/info/*.md5sums"))
+	if err != nil {
+		return nil, err
+	}
+
+	var files []File
+	for _, match := range matches {
+		data, err := ioutil.ReadFile(match)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, parseDpkgMd5sums(data)...)
+	}
+	return files, nil
+}
+
+// rpmBackend shells out to rpm --dump, which reports every file rpm knows
+// about along with its digest and whether it's a %config file (rpm's
+// equivalent of a pacman backup file).
+type rpmBackend struct {
+	root string
+}
+
+type rpmFileRecord struct {
+	path     string
+	hash     string
+	isConfig bool
+}
+
+// parseRpmDumpLine parses one line of `rpm --dump` output: whitespace-
+// separated columns path/size/mtime/md5/mode/.../config-flag, with path
+// relative to root to match every other File producer in this package.
+// ok is false for malformed lines (rpm --dump pads short entries, e.g.
+// ghost files, with fewer columns).
+func parseRpmDumpLine(line string) (rec rpmFileRecord, ok bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 10 {
+		return rpmFileRecord{}, false
+	}
+	return rpmFileRecord{
+		path:     strings.TrimPrefix(fields[0], "/"),
+		hash:     fields[3],
+		isConfig: fields[8] == "1",
+	}, true
+}
+
+func (r *rpmBackend) dump() ([]rpmFileRecord, error) {
+	out, err := exec.Command("rpm", "--root", r.root, "-qa", "--dump").Output()
+	if err != nil {
+		return nil, err
+	}
+
+	var records []rpmFileRecord
+	for _, line := range strings.Split(string(out), "\n") {
+		if rec, ok := parseRpmDumpLine(line); ok {
+			records = append(records, rec)
+		}
+	}
+	return records, nil
+}
+
+func (r *rpmBackend) BackupFiles() ([]File, error) {
+	records, err := r.dump()
+	if err != nil {
+		return nil, err
+	}
+	var files []File
+	for _, rec := range records {
+		if rec.isConfig {
+			files = append(files, File{Name: rec.path, Hash: rec.hash})
+		}
+	}
+	return files, nil
+}
+
+func (r *rpmBackend) OwnedFiles() ([]File, error) {
+	records, err := r.dump()
+	if err != nil {
+		return nil, err
+	}
+	var files []File
+	for _, rec := range records {
+		files = append(files, File{Name: rec.path})
+	}
+	return files, nil
+}
+
+// fileSet gives O(1) membership checks for a []File, replacing the O(n)
+// linear scans that dominate UnpackagedFile/MissingInRepo on a full rootfs.
+type fileSet map[string]struct{}
+
+func newFileSet(files []File) fileSet {
+	set := make(fileSet, len(files))
+	for _, file := range files {
+		set[file.Name] = struct{}{}
+	}
+	return set
+}
+
+func (s fileSet) Contains(name string) bool {
+	_, ok := s[name]
+	return ok
+}
+
+// jobs returns the configured worker count, defaulting to NumCPU.
+func (sc *Scanner) jobs() int {
+	if sc.Jobs > 0 {
+		return sc.Jobs
+	}
+	return runtime.NumCPU()
+}
+
+func (sc *Scanner) IsIgnored(path string) bool {
+	for _, glob := range sc.IgnoreGlobs {
+		matched, err := filepath.Match(glob, path)
+		if err != nil {
+			log.Fatalf("Match error: %s", err)
+		}
+		if matched {
+			return true
+		}
+	}
+	// .gitignore semantics only make sense, and are only cheap, when a repo
+	// was actually configured: with no -repo, GitIgnore() would recursively
+	// walk whatever directory the process happens to be running in.
+	if sc.Repo == "" {
+		return false
+	}
+	rel, err := filepath.Rel(sc.Root, path)
+	if err != nil {
+		return false
+	}
+	if sc.GitIgnore().Match(strings.Split(rel, string(filepath.Separator)), false) {
+		return true
+	}
+	return false
+}
+
+// Git lazily opens sc.Repo as a real git repository, replacing the old
+// `git` subprocess shell-outs.
+func (sc *Scanner) Git() *git.Repository {
+	if sc.gitRepo == nil {
+		repo, err := git.PlainOpen(sc.Repo)
+		if err != nil {
+			log.Fatalf("Error opening repo %q: %s", sc.Repo, err)
+		}
+		sc.gitRepo = repo
+	}
+	return sc.gitRepo
+}
+
+// GitIgnore returns a matcher built from the repo's .gitignore files, used
+// in addition to IgnoreGlobs.
+func (sc *Scanner) GitIgnore() gitignore.Matcher {
+	if sc.gitIgnore == nil {
+		patterns, err := gitignore.ReadPatterns(osfs.New(sc.Repo), nil)
+		if err != nil {
+			log.Fatalf("Error reading .gitignore: %s", err)
+		}
+		sc.gitIgnore = gitignore.NewMatcher(patterns)
+	}
+	return sc.gitIgnore
+}
+
+// PackageBackend is whatever package manager owns the installed files on
+// Root: it reports the files a package wants tracked for changes
+// (BackupFiles, e.g. pacman's %BACKUP%/dpkg's conffiles) and every file any
+// package owns (OwnedFiles), so every scope works the same way regardless
+// of distro.
+type PackageBackend interface {
+	BackupFiles() ([]File, error)
+	OwnedFiles() ([]File, error)
+}
+
+// releasableBackend is implemented by backends that hold a native handle
+// (e.g. alpm) that needs to be closed explicitly.
+type releasableBackend interface {
+	Release()
+}
+
+// detectBackend guesses the package manager in use from the on-disk layout
+// under root, defaulting to pacman.
+func detectBackend(root string) string {
+	switch {
+	case pathExists(filepath.Join(root, "var/lib/pacman")):
+		return "pacman"
+	case pathExists(filepath.Join(root, "var/lib/dpkg")):
+		return "dpkg"
+	case pathExists(filepath.Join(root, "var/lib/rpm")),
+		pathExists(filepath.Join(root, "usr/lib/sysimage/rpm")):
+		return "rpm"
+	default:
+		return "pacman"
+	}
+}
+
+func pathExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func (sc *Scanner) PackageBackend() PackageBackend {
+	if sc.packageBackend == nil {
+		name := sc.Backend
+		if name == "" {
+			name = detectBackend(sc.Root)
+		}
+		switch name {
+		case "pacman":
+			sc.packageBackend = &pacmanBackend{root: sc.Root, db: sc.DB}
+		case "dpkg":
+			sc.packageBackend = &dpkgBackend{root: sc.Root}
+		case "rpm":
+			sc.packageBackend = &rpmBackend{root: sc.Root}
+		default:
+			log.Fatalf("unknown backend: %s", name)
+		}
+	}
+	return sc.packageBackend
+}
+
+// Release closes any native handle the package backend is holding.
+func (sc *Scanner) Release() {
+	if r, ok := sc.packageBackend.(releasableBackend); ok {
+		r.Release()
+	}
+}
+
+func (sc *Scanner) BackupFile() []File {
+	if sc.backupFile == nil {
+		files, err := sc.PackageBackend().BackupFiles()
+		if err != nil {
+			log.Fatalf("Error reading backup files: %s", err)
+		}
+		sc.backupFile = files
+	}
+	return sc.backupFile
+}
+
+// AllFile walks root() with a concurrent producer/consumer pipeline:
+// filepath.WalkDir applies the ignore filter and feeds the remaining paths
+// into a channel drained by sc.jobs() goroutines, which strip the leading
+// slash and collect them. Files are listed, not hashed.
+func (sc *Scanner) AllFile() []File {
+	if sc.allFile == nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		paths := make(chan string, sc.jobs())
+		results := make(chan File, sc.jobs())
+
+		var wg sync.WaitGroup
+		for i := 0; i < sc.jobs(); i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for path := range paths {
+					results <- File{Name: path[1:]}
+				}
+			}()
+		}
+		go func() {
+			wg.Wait()
+			close(results)
+		}()
+
+		walkErr := make(chan error, 1)
+		go func() {
+			defer close(paths)
+			walkErr <- filepath.WalkDir(sc.root(), func(path string, d fs.DirEntry, err error) error {
+				if err != nil {
+					if os.IsPermission(err) {
+						log.Printf("Skipping file: %s", err)
+						return nil
+					}
+					return err
+				}
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				default:
+				}
+				if sc.IsIgnored(path) {
+					if d.IsDir() {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+				if d.IsDir() {
+					return nil
+				}
+				paths <- path
+				return nil
+			})
+		}()
+
+		for file := range results {
+			sc.allFile = append(sc.allFile, file)
+		}
+		if err := <-walkErr; err != nil {
+			log.Fatalf("Error finding unpackaged file: %s", err)
+		}
+	}
+	return sc.allFile
+}
+
+func (sc *Scanner) AllPackageFile() []File {
+	if sc.allPackageFile == nil {
+		files, err := sc.PackageBackend().OwnedFiles()
+		if err != nil {
+			log.Fatalf("Error reading owned files: %s", err)
+		}
+		sc.allPackageFile = files
+	}
+	return sc.allPackageFile
+}
+
+// backupFileHashAlgo is the digest algorithm package backends report backup
+// file hashes in. It's independent of -hash/sc.HashAlgo, which only governs
+// self-consistent comparisons like DiffRepoFile: pacman's BackupFile.Hash
+// and dpkg's conffile/md5sums digests are always MD5, so comparing them
+// against a sha256 or blake3 sum of the on-disk file would always report
+// the file as modified.
+const backupFileHashAlgo = "md5"
+
+// ModifiedBackupFile hashes every package backup file with sc.jobs() worker
+// goroutines, since the candidate list is already known and fixed.
+func (sc *Scanner) ModifiedBackupFile() []File {
+	if sc.modifiedBackupFile == nil {
+		backups := sc.BackupFile()
+		work := make(chan File, len(backups))
+		modified := make(chan File, len(backups))
+		errs := make(chan error, len(backups))
+
+		var wg sync.WaitGroup
+		for i := 0; i < sc.jobs(); i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for file := range work {
+					fullname := filepath.Join(sc.Root, file.Name)
+					if sc.IsIgnored(fullname) {
+						continue
+					}
+					actual, err := sc.filehashAlgo(fullname, backupFileHashAlgo)
+					if err != nil {
+						if os.IsPermission(err) {
+							log.Printf("Skipping file: %s\n", err)
+							continue
+						}
+						errs <- fmt.Errorf("hashing %s: %w", fullname, err)
+						continue
+					}
+					if actual != file.Hash {
+						modified <- file
+					}
+				}
+			}()
+		}
+		for _, file := range backups {
+			work <- file
+		}
+		close(work)
+		go func() {
+			wg.Wait()
+			close(modified)
+			close(errs)
+		}()
+
+		for file := range modified {
+			sc.modifiedBackupFile = append(sc.modifiedBackupFile, file)
+		}
+		if err := <-errs; err != nil {
+			log.Fatalf("Error calculating actual hash: %s", err)
+		}
+	}
+	return sc.modifiedBackupFile
+}
+
+func (sc *Scanner) UnpackagedFile() []File {
+	if sc.unpackagedFile == nil {
+		packaged := newFileSet(sc.AllPackageFile())
+		for _, file := range sc.AllFile() {
+			if !packaged.Contains(file.Name) {
+				sc.unpackagedFile = append(sc.unpackagedFile, file)
+			}
+		}
+	}
+	return sc.unpackagedFile
+}
+
+func (sc *Scanner) RepoFile() []File {
+	if sc.repoFile == nil {
+		idx, err := sc.Git().Storer.Index()
+		if err != nil {
+			log.Fatalf("Error reading repo index: %s", err)
+		}
+		for _, entry := range idx.Entries {
+			sc.repoFile = append(sc.repoFile, File{Name: entry.Name})
+		}
+	}
+	return sc.repoFile
+}
+
+func (sc *Scanner) DiffRepoFile() []File {
+	if sc.diffRepoFile == nil {
+		for _, file := range sc.RepoFile() {
+			realpath := filepath.Join(sc.Root, file.Name)
+			repopath := filepath.Join(sc.Repo, file.Name)
+			realhash, err := sc.filehash(realpath)
+			if err != nil && !os.IsNotExist(err) {
+				if os.IsPermission(err) {
+					log.Printf("Skipping file: %s", err)
+					continue
+				}
+				log.Fatalf("Error looking for modified repo files (real): %s", err)
+			}
+			repohash, err := sc.filehash(repopath)
+			if err != nil && !os.IsNotExist(err) {
+				if os.IsPermission(err) {
+					log.Printf("Skipping file: %s", err)
+					continue
+				}
+				log.Fatalf("Error looking for modified repo files (repo): %s", err)
+			}
+			if realhash != repohash {
+				sc.diffRepoFile = append(sc.diffRepoFile, file)
+			}
+		}
+	}
+	return sc.diffRepoFile
+}
+
+func (sc *Scanner) MissingInRepo() []File {
+	if sc.missingInRepo == nil {
+		repo := newFileSet(sc.RepoFile())
+		for _, file := range sc.ModifiedBackupFile() {
+			if !repo.Contains(file.Name) {
+				sc.missingInRepo = append(sc.missingInRepo, file)
+			}
+		}
+		for _, file := range sc.UnpackagedFile() {
+			if !repo.Contains(file.Name) {
+				sc.missingInRepo = append(sc.missingInRepo, file)
+			}
+		}
+	}
+	return sc.missingInRepo
+}
@@ -0,0 +1,181 @@
+package scan
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseDpkgConffiles(t *testing.T) {
+	tests := []struct {
+		name   string
+		status string
+		want   []File
+	}{
+		{
+			name: "single package",
+			status: "Package: foo\n" +
+				"Status: install ok installed\n" +
+				"Conffiles:\n" +
+				" /etc/foo.conf abc123\n" +
+				" /etc/foo/bar.conf def456\n" +
+				"Description: foo\n",
+			want: []File{
+				{Name: "etc/foo.conf", Hash: "abc123"},
+				{Name: "etc/foo/bar.conf", Hash: "def456"},
+			},
+		},
+		{
+			name: "multiple packages, conffiles block ends at a non-indented line",
+			status: "Package: foo\n" +
+				"Conffiles:\n" +
+				" /etc/foo.conf abc123\n" +
+				"Package: bar\n" +
+				"Conffiles:\n" +
+				" /etc/bar.conf 789xyz\n",
+			want: []File{
+				{Name: "etc/foo.conf", Hash: "abc123"},
+				{Name: "etc/bar.conf", Hash: "789xyz"},
+			},
+		},
+		{
+			name:   "no Conffiles section",
+			status: "Package: foo\nStatus: install ok installed\n",
+			want:   nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseDpkgConffiles([]byte(tt.status))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseDpkgConffiles() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDpkgMd5sums(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []File
+	}{
+		{
+			name: "typical md5sums file",
+			data: "d41d8cd98f00b204e9800998ecf8427e  usr/bin/foo\n" +
+				"098f6bcd4621d373cade4e832627b4f6  etc/foo.conf\n",
+			want: []File{
+				{Name: "usr/bin/foo", Hash: "d41d8cd98f00b204e9800998ecf8427e"},
+				{Name: "etc/foo.conf", Hash: "098f6bcd4621d373cade4e832627b4f6"},
+			},
+		},
+		{
+			name: "leading slash is stripped to match other backends",
+			data: "d41d8cd98f00b204e9800998ecf8427e  /etc/foo.conf\n",
+			want: []File{{Name: "etc/foo.conf", Hash: "d41d8cd98f00b204e9800998ecf8427e"}},
+		},
+		{
+			name: "malformed line is skipped",
+			data: "not a valid line\n",
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseDpkgMd5sums([]byte(tt.data))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseDpkgMd5sums() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRpmDumpLine(t *testing.T) {
+	tests := []struct {
+		name   string
+		line   string
+		want   rpmFileRecord
+		wantOK bool
+	}{
+		{
+			name:   "config file",
+			line:   "/etc/foo.conf 123 1700000000 abc123 0100644 root root 0 1 0 0 0 X",
+			want:   rpmFileRecord{path: "etc/foo.conf", hash: "abc123", isConfig: true},
+			wantOK: true,
+		},
+		{
+			name:   "non-config file",
+			line:   "/usr/bin/foo 456 1700000000 def456 0100755 root root 0 0 0 0 0 X",
+			want:   rpmFileRecord{path: "usr/bin/foo", hash: "def456", isConfig: false},
+			wantOK: true,
+		},
+		{
+			name:   "too few columns",
+			line:   "/etc/foo.conf 123 1700000000",
+			wantOK: false,
+		},
+		{
+			name:   "blank line",
+			line:   "",
+			wantOK: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseRpmDumpLine(tt.line)
+			if ok != tt.wantOK {
+				t.Fatalf("parseRpmDumpLine() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if ok && got != tt.want {
+				t.Errorf("parseRpmDumpLine() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHashCacheSaveLoadRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hashes.db")
+	info := fakeFileInfo{size: 42, modTime: time.Unix(1700000000, 0)}
+
+	hc := loadHashCacheFrom(path)
+	hc.Store("/etc/foo.conf", "md5", info, "abc123")
+	if err := hc.Save(); err != nil {
+		t.Fatalf("Save() error = %s", err)
+	}
+
+	reloaded := loadHashCacheFrom(path)
+	sum, ok := reloaded.Lookup("/etc/foo.conf", "md5", info)
+	if !ok || sum != "abc123" {
+		t.Errorf("Lookup() after reload = (%q, %v), want (%q, true)", sum, ok, "abc123")
+	}
+}
+
+func TestHashCacheLookupMissesOnAlgoChange(t *testing.T) {
+	info := fakeFileInfo{size: 42, modTime: time.Unix(1700000000, 0)}
+
+	hc := loadHashCacheFrom(filepath.Join(t.TempDir(), "hashes.db"))
+	hc.Store("/etc/foo.conf", "md5", info, "abc123")
+
+	if sum, ok := hc.Lookup("/etc/foo.conf", "sha256", info); ok {
+		t.Errorf("Lookup() with a different algo = (%q, true), want a miss", sum)
+	}
+	if sum, ok := hc.Lookup("/etc/foo.conf", "md5", info); !ok || sum != "abc123" {
+		t.Errorf("Lookup() with the original algo = (%q, %v), want (%q, true)", sum, ok, "abc123")
+	}
+}
+
+// fakeFileInfo is a minimal os.FileInfo for exercising hashCache without
+// touching the real filesystem.
+type fakeFileInfo struct {
+	os.FileInfo
+	size    int64
+	modTime time.Time
+}
+
+func (f fakeFileInfo) Size() int64        { return f.size }
+func (f fakeFileInfo) ModTime() time.Time { return f.modTime }